@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// listenTLS and its companions turn the local listener from an open,
+// plaintext proxy into one that requires the caller to authenticate,
+// either via a client certificate (-client-ca) or an HMAC bearer token
+// (-auth-token-secret), and restricts the CRNs each authenticated identity
+// may operate on (-acl-file). All are opt-in: with none set, the listener
+// behaves exactly as before.
+var (
+	listenTLS       = flag.Bool("listen-tls", false, "terminate TLS on :8019 instead of listening in plaintext")
+	serverCert      = flag.String("server-cert", "", "PEM certificate presented by the :8019 listener when -listen-tls is set")
+	serverKey       = flag.String("server-key", "", "PEM key matching -server-cert")
+	clientCA        = flag.String("client-ca", "", "PEM CA bundle; when set, require a caller certificate signed by it (mTLS) and identify the caller by its certificate's CN")
+	authTokenSecret = flag.String("auth-token-secret", "", "HMAC secret accepting bearer tokens '<identity>.<hex-hmac>' in the Authorization header, for callers that cannot present a client certificate")
+	aclFile         = flag.String("acl-file", "", `JSON file mapping an authenticated identity to the CRNs it may operate on, e.g. {"till-3": ["123456"]}; identities with no entry may operate on no CRN`)
+)
+
+// acl maps an authenticated identity to the CRNs it's allowed to operate
+// on. It's populated once at startup by loadACL.
+var acl map[string][]string
+
+// authRequired reports whether either authentication mode is configured.
+func authRequired() bool {
+	return *clientCA != "" || *authTokenSecret != ""
+}
+
+// loadACL reads -acl-file into acl, if set.
+func loadACL() error {
+	if *aclFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(*aclFile)
+	if err != nil {
+		return fmt.Errorf("reading -acl-file %s: %w", *aclFile, err)
+	}
+	if err := json.Unmarshal(data, &acl); err != nil {
+		return fmt.Errorf("parsing -acl-file %s: %w", *aclFile, err)
+	}
+
+	return nil
+}
+
+// serverTLSConfig builds the *tls.Config for -listen-tls, requiring and
+// verifying caller certificates against -client-ca when set.
+func serverTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(*serverCert, *serverKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading -server-cert/-server-key: %w", err)
+	}
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if *clientCA == "" {
+		return cfg, nil
+	}
+
+	caPEM, err := os.ReadFile(*clientCA)
+	if err != nil {
+		return nil, fmt.Errorf("reading -client-ca %s: %w", *clientCA, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in -client-ca %s", *clientCA)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return cfg, nil
+}
+
+// authenticate identifies the caller behind r: the CN of its verified
+// client certificate under -client-ca, or the identity claim of a valid
+// -auth-token-secret bearer token. It returns ("", nil) when neither mode
+// is configured, since authentication is opt-in.
+func authenticate(r *http.Request) (string, error) {
+	if *clientCA != "" {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			return "", &apiError{status: http.StatusUnauthorized, message: "client certificate required"}
+		}
+		return r.TLS.PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	if *authTokenSecret != "" {
+		return verifyBearerToken(r.Header.Get("Authorization"), *authTokenSecret)
+	}
+
+	return "", nil
+}
+
+// verifyBearerToken parses an "Authorization: Bearer <identity>.<hex-hmac>"
+// header and checks the hmac against secret, returning identity on match.
+func verifyBearerToken(header, secret string) (string, error) {
+	const prefix = "Bearer "
+	if !strings.HasPrefix(header, prefix) {
+		return "", &apiError{status: http.StatusUnauthorized, message: "missing bearer token"}
+	}
+
+	identity, sigHex, ok := strings.Cut(strings.TrimPrefix(header, prefix), ".")
+	if !ok {
+		return "", &apiError{status: http.StatusUnauthorized, message: "malformed bearer token"}
+	}
+
+	sig, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return "", &apiError{status: http.StatusUnauthorized, message: "malformed bearer token signature"}
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(identity))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", &apiError{status: http.StatusUnauthorized, message: "invalid bearer token"}
+	}
+
+	return identity, nil
+}
+
+// authorizeCRN checks that identity, as established by authenticate, may
+// operate on crn, per -acl-file. The check is skipped entirely when
+// authentication isn't configured; once it is, an identity with no ACL
+// entry is denied by default rather than waved through.
+func authorizeCRN(identity, crn string) error {
+	if !authRequired() {
+		return nil
+	}
+
+	allowed, ok := acl[identity]
+	if !ok {
+		return &apiError{status: http.StatusForbidden, message: fmt.Sprintf("identity %q has no acl-file entry and may not operate on any crn", identity)}
+	}
+
+	for _, c := range allowed {
+		if c == crn {
+			return nil
+		}
+	}
+
+	return &apiError{status: http.StatusForbidden, message: fmt.Sprintf("identity %q is not permitted to operate on crn %s", identity, crn)}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// withAuthFlags sets clientCA/authTokenSecret/acl for the duration of a
+// test and restores them afterward, since authorizeCRN and authenticate
+// read those package-level flag vars directly.
+func withAuthFlags(t *testing.T, ca, tokenSecret string, aclData map[string][]string) {
+	t.Helper()
+
+	oldCA, oldToken, oldACL := *clientCA, *authTokenSecret, acl
+	*clientCA = ca
+	*authTokenSecret = tokenSecret
+	acl = aclData
+
+	t.Cleanup(func() {
+		*clientCA = oldCA
+		*authTokenSecret = oldToken
+		acl = oldACL
+	})
+}
+
+func TestAuthorizeCRN(t *testing.T) {
+	cases := []struct {
+		name     string
+		ca       string
+		acl      map[string][]string
+		identity string
+		crn      string
+		wantErr  bool
+	}{
+		{
+			name:    "auth not configured allows any crn",
+			acl:     nil,
+			crn:     "123456",
+			wantErr: false,
+		},
+		{
+			name:     "identity with no acl entry is denied",
+			ca:       "configured",
+			acl:      map[string][]string{"till-1": {"123456"}},
+			identity: "till-2",
+			crn:      "123456",
+			wantErr:  true,
+		},
+		{
+			name:     "identity with acl entry but wrong crn is denied",
+			ca:       "configured",
+			acl:      map[string][]string{"till-1": {"999999"}},
+			identity: "till-1",
+			crn:      "123456",
+			wantErr:  true,
+		},
+		{
+			name:     "identity allowed for crn",
+			ca:       "configured",
+			acl:      map[string][]string{"till-1": {"123456"}},
+			identity: "till-1",
+			crn:      "123456",
+			wantErr:  false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			withAuthFlags(t, tc.ca, "", tc.acl)
+
+			err := authorizeCRN(tc.identity, tc.crn)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("authorizeCRN(%q, %q) error = %v, wantErr %v", tc.identity, tc.crn, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyBearerToken(t *testing.T) {
+	const secret = "shop-lan-secret"
+
+	sign := func(identity string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(identity))
+		return "Bearer " + identity + "." + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	t.Run("valid token returns identity", func(t *testing.T) {
+		identity, err := verifyBearerToken(sign("till-1"), secret)
+		if err != nil {
+			t.Fatalf("verifyBearerToken: %v", err)
+		}
+		if identity != "till-1" {
+			t.Errorf("identity = %q, want %q", identity, "till-1")
+		}
+	})
+
+	t.Run("tampered identity is rejected", func(t *testing.T) {
+		header := sign("till-1")
+		tampered := header[:len("Bearer ")] + "till-2" + header[len("Bearer till-1"):]
+		if _, err := verifyBearerToken(tampered, secret); err == nil {
+			t.Error("expected error for tampered identity, got nil")
+		}
+	})
+
+	t.Run("wrong secret is rejected", func(t *testing.T) {
+		if _, err := verifyBearerToken(sign("till-1"), "wrong-secret"); err == nil {
+			t.Error("expected error for wrong secret, got nil")
+		}
+	})
+
+	t.Run("missing header is rejected", func(t *testing.T) {
+		if _, err := verifyBearerToken("", secret); err == nil {
+			t.Error("expected error for missing header, got nil")
+		}
+	})
+
+	t.Run("malformed token is rejected", func(t *testing.T) {
+		if _, err := verifyBearerToken("Bearer not-a-valid-token", secret); err == nil {
+			t.Error("expected error for malformed token, got nil")
+		}
+	})
+}
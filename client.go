@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultClientTimeout bounds a single upstream call so a stalled
+// ecrm.taxservice.am request can't hang a handler goroutine forever.
+const defaultClientTimeout = 30 * time.Second
+
+// registrationClient is used for the plain-HTTPS uploadCertificate call
+// registerCertificate makes (no client certificate, since the point is to
+// establish one), bounded by the same timeout as a pooled Client so it
+// can't hang a handler or renewal goroutine either.
+var registrationClient = &http.Client{Timeout: defaultClientTimeout}
+
+// Client is a pooled, mTLS-authenticated HTTP client for a single CRN's
+// certificate pair. The underlying *http.Client keeps its TLS connections
+// alive between calls, so repeated requests for the same CRN avoid both the
+// cost of a new handshake and the cost of re-reading the cert/key from disk.
+type Client struct {
+	crn        string
+	httpClient *http.Client
+}
+
+// clients caches one Client per CRN so handlers reuse the same pooled
+// connection instead of rebuilding it on every request.
+var clients sync.Map // crn string -> *Client
+
+// clientFor returns the cached Client for crn, registering its certificate
+// with ecrm.taxservice.am and building a fresh *http.Client on first use.
+func clientFor(ctx context.Context, crn string) (*Client, error) {
+	if c, ok := clients.Load(crn); ok {
+		return c.(*Client), nil
+	}
+
+	certPath, keyPath, err := findCertificateFiles(crn)
+	if err != nil {
+		return nil, err
+	}
+
+	status, body, err := registerCertificate(ctx, certPath, keyPath, crn)
+	if err != nil {
+		return nil, err
+	}
+	if status < 200 || status >= 300 {
+		return nil, ErrUpstream(status, body)
+	}
+
+	c, err := newClient(crn, certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	actual, _ := clients.LoadOrStore(crn, c)
+	return actual.(*Client), nil
+}
+
+// newClient builds a Client whose transport presents the certificate pair
+// at certPath/keyPath for every request made against ecrm.taxservice.am.
+func newClient(crn, certPath, keyPath string) (*Client, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate pair for crn %s: %w", crn, err)
+	}
+
+	return &Client{
+		crn: crn,
+		httpClient: &http.Client{
+			Timeout: defaultClientTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					Certificates: []tls.Certificate{cert},
+				},
+			},
+		},
+	}, nil
+}
+
+// invalidateClient drops the cached client for crn so the next request
+// rebuilds it from the certificate files currently on disk.
+func invalidateClient(crn string) {
+	clients.Delete(crn)
+}
+
+// do POSTs jsonData to endpoint under baseURL and returns the upstream
+// status code and raw body, letting the caller decide how to surface a
+// non-2xx response instead of folding it into an error.
+func (c *Client) do(ctx context.Context, endpoint string, jsonData map[string]any) (int, []byte, error) {
+	jsonBytes, err := json.Marshal(jsonData)
+	if err != nil {
+		return 0, nil, fmt.Errorf("JSON encoding error: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+endpoint, bytes.NewReader(jsonBytes))
+	if err != nil {
+		return 0, nil, fmt.Errorf("building request for %s: %w", endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error in %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("reading response from %s: %w", endpoint, err)
+	}
+
+	return resp.StatusCode, body, nil
+}
+
+func (c *Client) CheckConnection(ctx context.Context, jsonData map[string]any) (int, []byte, error) {
+	return c.do(ctx, "checkConnection", jsonData)
+}
+
+func (c *Client) Activate(ctx context.Context, jsonData map[string]any) (int, []byte, error) {
+	return c.do(ctx, "activate", jsonData)
+}
+
+func (c *Client) ConfigureDepartments(ctx context.Context, jsonData map[string]any) (int, []byte, error) {
+	return c.do(ctx, "configureDepartments", jsonData)
+}
+
+func (c *Client) GetGoodList(ctx context.Context, jsonData map[string]any) (int, []byte, error) {
+	return c.do(ctx, "getGoodList", jsonData)
+}
+
+func (c *Client) Print(ctx context.Context, jsonData map[string]any) (int, []byte, error) {
+	return c.do(ctx, "print", jsonData)
+}
+
+func (c *Client) PrintCopy(ctx context.Context, jsonData map[string]any) (int, []byte, error) {
+	return c.do(ctx, "printCopy", jsonData)
+}
+
+func (c *Client) GetReturnedReceiptInfo(ctx context.Context, jsonData map[string]any) (int, []byte, error) {
+	return c.do(ctx, "getReturnedReceiptInfo", jsonData)
+}
+
+func (c *Client) PrintReturnReceipt(ctx context.Context, jsonData map[string]any) (int, []byte, error) {
+	return c.do(ctx, "printReturnReceipt", jsonData)
+}
+
+// clientMethod dispatches an endpoint key to the Client method that serves
+// it, letting runRequest look up the right call without a type switch.
+type clientMethod func(*Client, context.Context, map[string]any) (int, []byte, error)
+
+var clientMethods = map[string]clientMethod{
+	"checkConnection":        (*Client).CheckConnection,
+	"activate":               (*Client).Activate,
+	"configureDepartments":   (*Client).ConfigureDepartments,
+	"getGoodList":            (*Client).GetGoodList,
+	"print":                  (*Client).Print,
+	"printCopy":              (*Client).PrintCopy,
+	"getReturnedReceiptInfo": (*Client).GetReturnedReceiptInfo,
+	"printReturnReceipt":     (*Client).PrintReturnReceipt,
+}
+
+// registerCertificate POSTs the certificate pair at certPath/keyPath to
+// ecrm.taxservice.am's uploadCertificate endpoint, registering it for crn.
+// This is a plain HTTPS call (no client certificate, since the point is to
+// establish one) so it uses registrationClient rather than a pooled Client.
+func registerCertificate(ctx context.Context, certPath, keyPath, crn string) (int, []byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	if err := writeMultipartFile(writer, "certificate", certPath); err != nil {
+		return 0, nil, err
+	}
+	if err := writeMultipartFile(writer, "key", keyPath); err != nil {
+		return 0, nil, err
+	}
+	if err := writer.WriteField("crn", crn); err != nil {
+		return 0, nil, fmt.Errorf("writing crn field: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return 0, nil, fmt.Errorf("closing multipart writer: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"uploadCertificate", &buf)
+	if err != nil {
+		return 0, nil, fmt.Errorf("building uploadCertificate request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := registrationClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error uploading certificate for crn %s: %w", crn, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("reading uploadCertificate response: %w", err)
+	}
+
+	log.Printf("certificate registered for crn %s (status %d)", crn, resp.StatusCode)
+	return resp.StatusCode, body, nil
+}
+
+func writeMultipartFile(writer *multipart.Writer, field, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	part, err := writer.CreateFormFile(field, filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("creating form file %s: %w", field, err)
+	}
+
+	if _, err := io.Copy(part, f); err != nil {
+		return fmt.Errorf("copying %s into form: %w", field, err)
+	}
+
+	return nil
+}
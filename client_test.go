@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// generateSelfSignedCert writes a throwaway self-signed certificate and key
+// pair to dir, for tests that need a certificate Client can load from disk.
+func generateSelfSignedCert(t *testing.T, dir, name string) (certPath, keyPath string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshaling key: %v", err)
+	}
+
+	certPath = filepath.Join(dir, name+".crt")
+	keyPath = filepath.Join(dir, name+".key")
+
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("writing %s: %v", certPath, err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("writing %s: %v", keyPath, err)
+	}
+
+	return certPath, keyPath
+}
+
+// TestClientDoPresentsClientCertAndSurfacesStatus exercises Client.do
+// against an httptest.NewUnstartedServer requiring a client certificate,
+// checking both that the mTLS handshake succeeds and that a non-2xx
+// upstream response reaches the caller verbatim instead of being hidden.
+func TestClientDoPresentsClientCertAndSurfacesStatus(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := generateSelfSignedCert(t, dir, "123456")
+
+	var sawPeerCert bool
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sawPeerCert = len(r.TLS.PeerCertificates) > 0
+
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decoding request body: %v", err)
+		}
+
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"reason":"rejected"}`))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	client, err := newClient("123456", certPath, keyPath)
+	if err != nil {
+		t.Fatalf("newClient: %v", err)
+	}
+	client.httpClient.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify = true
+
+	oldBaseURL := baseURL
+	baseURL = srv.URL + "/"
+	defer func() { baseURL = oldBaseURL }()
+
+	status, body, err := client.do(context.Background(), "checkConnection", map[string]any{"crn": "123456"})
+	if err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	if !sawPeerCert {
+		t.Error("server did not see a client certificate, mTLS was not presented")
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if string(body) != `{"reason":"rejected"}` {
+		t.Errorf("body = %s, want the upstream body verbatim", body)
+	}
+}
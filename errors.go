@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// apiError is an error that knows which HTTP status code it should be
+// rendered with, letting internal/api/render pick the right status instead
+// of always falling back to 500.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string   { return e.message }
+func (e *apiError) StatusCode() int { return e.status }
+
+// badRequestError builds an apiError for a 400 response, for validation
+// failures that don't warrant a dedicated named constructor.
+func badRequestError(format string, args ...any) error {
+	return &apiError{status: http.StatusBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+// ErrMissingField reports that the request body was missing one of its
+// endpoint's required fields.
+func ErrMissingField(field string) error {
+	return &apiError{status: http.StatusBadRequest, message: fmt.Sprintf("field '%s' is missing", field)}
+}
+
+// ErrCertNotFound reports that no certificate pair could be located on disk
+// for crn.
+func ErrCertNotFound(crn string, cause error) error {
+	return &apiError{status: http.StatusBadRequest, message: fmt.Sprintf("no certificate found for crn %s: %v", crn, cause)}
+}
+
+// ErrUpstream wraps a non-2xx response from ecrm.taxservice.am, preserving
+// its status code and body verbatim instead of flattening it to a 500.
+func ErrUpstream(status int, body []byte) error {
+	return &apiError{status: status, message: string(body)}
+}
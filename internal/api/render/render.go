@@ -0,0 +1,62 @@
+// Package render writes consistently-shaped JSON responses so every
+// handler failure, regardless of where it originates, reaches the caller
+// in the same {timestamp, status, error, message, path} envelope.
+package render
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// errorBody is the JSON shape written for every failed API response.
+type errorBody struct {
+	Timestamp string `json:"timestamp"`
+	Status    int    `json:"status"`
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+	Path      string `json:"path"`
+}
+
+// statusCoder is implemented by errors that know which HTTP status they
+// should be rendered with.
+type statusCoder interface {
+	StatusCode() int
+}
+
+// JSON writes v as a JSON body with the given status code.
+func JSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("render: encoding response: %v", err)
+	}
+}
+
+// Error writes err as a structured JSON body. Errors implementing
+// statusCoder render with their own status code; any other error renders
+// as a 500.
+func Error(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	if sc, ok := err.(statusCoder); ok {
+		status = sc.StatusCode()
+	}
+	render(w, r, status, err.Error())
+}
+
+// BadRequest writes err as a 400 response, for validation failures that
+// don't carry their own status code.
+func BadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	render(w, r, http.StatusBadRequest, err.Error())
+}
+
+func render(w http.ResponseWriter, r *http.Request, status int, message string) {
+	JSON(w, status, errorBody{
+		Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05.000") + "+0000",
+		Status:    status,
+		Error:     http.StatusText(status),
+		Message:   message,
+		Path:      r.URL.Path,
+	})
+}
@@ -7,29 +7,46 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
-	"time"
+	"strings"
+
+	"virtualHDM/internal/api/render"
 )
 
-const baseURL = "https://ecrm.taxservice.am/taxsystem-rs-vcr/api/v1.0/"
-
-var endpoints = map[string]string{
-	"checkConnection":        "checkConnection",
-	"activate":               "activate",
-	"configureDepartments":   "configureDepartments",
-	"getGoodList":            "getGoodList",
-	"print":                  "print",
-	"printCopy":              "printCopy",
-	"getReturnedReceiptInfo": "getReturnedReceiptInfo",
-	"printReturnReceipt":     "printReturnReceipt",
-	"uploadCertificate":      "uploadCertificate",
+// baseURL is a var rather than a const so tests can point Client.do and
+// registerCertificate at an httptest.NewTLSServer instead of the real
+// taxservice.
+var baseURL = "https://ecrm.taxservice.am/taxsystem-rs-vcr/api/v1.0/"
+
+// EndpointSpec declares one locally-routed endpoint: the path it's served
+// on, the upstream taxservice path it forwards to, the HTTP method it
+// accepts, and the request fields it requires. Adding an upstream endpoint
+// that needs a typed Client method and per-field validation means adding
+// one entry here plus its Client method and clientMethods entry, since
+// runRequest rejects an UpstreamPath with no clientMethods entry; endpoints
+// that don't need either can go through /proxy/{name} instead without a
+// code change.
+type EndpointSpec struct {
+	Path           string
+	UpstreamPath   string
+	Method         string
+	RequiredFields []string
 }
 
-type Crn struct {
-	Value string `json:"crn"`
+// endpoints lists every locally-routed endpoint. Each is registered in a
+// loop by main, and its RequiredFields drive validateFields at the start of
+// runRequest.
+var endpoints = []EndpointSpec{
+	{Path: "/checkConnection", UpstreamPath: "checkConnection", Method: http.MethodPost, RequiredFields: []string{"crn"}},
+	{Path: "/activate", UpstreamPath: "activate", Method: http.MethodPost, RequiredFields: []string{"crn"}},
+	{Path: "/configureDepartments", UpstreamPath: "configureDepartments", Method: http.MethodPost, RequiredFields: []string{"crn"}},
+	{Path: "/getGoodList", UpstreamPath: "getGoodList", Method: http.MethodPost, RequiredFields: []string{"crn"}},
+	{Path: "/print", UpstreamPath: "print", Method: http.MethodPost, RequiredFields: []string{"crn", "dep"}},
+	{Path: "/printCopy", UpstreamPath: "printCopy", Method: http.MethodPost, RequiredFields: []string{"crn"}},
+	{Path: "/getReturnedReceiptInfo", UpstreamPath: "getReturnedReceiptInfo", Method: http.MethodPost, RequiredFields: []string{"crn"}},
+	{Path: "/printReturnReceipt", UpstreamPath: "printReturnReceipt", Method: http.MethodPost, RequiredFields: []string{"crn", "receiptId"}},
+	{Path: "/uploadCertificate", UpstreamPath: "uploadCertificate", Method: http.MethodPost, RequiredFields: []string{"crn"}},
 }
 
 func findCertificateFiles(crn string) (string, string, error) {
@@ -53,188 +70,243 @@ func findCertificateFiles(crn string) (string, string, error) {
 	return certFiles[0], keyFiles[0], nil
 }
 
-func checkCertificates(crn string) (string, string, error) {
-	certPath, keyPath, err := findCertificateFiles(crn)
-	if err != nil {
-		return "", "", err
-	}
+// validateFields checks that every field in required is present in
+// jsonData, giving the crn field an extra numeric-string check since every
+// endpoint relies on it to locate a certificate pair.
+func validateFields(jsonData map[string]any, required []string) error {
+	for _, field := range required {
+		v, ok := jsonData[field]
+		if !ok {
+			return ErrMissingField(field)
+		}
 
-	if _, err := os.Stat(certPath); os.IsNotExist(err) {
-		return "", "", fmt.Errorf("certificate file not found: %s", certPath)
-	}
-	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
-		return "", "", fmt.Errorf("key file not found: %s", keyPath)
-	}
+		if field != "crn" {
+			continue
+		}
 
-	log.Println("Certificates found successfully")
+		crn, ok := v.(string)
+		if !ok {
+			return badRequestError("field 'crn' must be a string")
+		}
+		if _, err := strconv.Atoi(crn); err != nil {
+			return badRequestError("field 'crn' must contain only numbers")
+		}
+	}
 
-	uploadURL := fmt.Sprintf("%s%s", baseURL, "uploadCertificate")
+	return nil
+}
 
-	virtualHdm := exec.Command(
-		"curl", "-X", "POST",
-		uploadURL,
-		"-H", "Content-Type: multipart/form-data",
-		"-F", fmt.Sprintf("certificate=@%s", certPath),
-		"-F", fmt.Sprintf("key=@%s", keyPath),
-		"-F", fmt.Sprintf("crn=%s", crn),
-	)
+// runRequest validates jsonData against spec, then dispatches to the
+// matching Client method (or, for uploadCertificate, re-registers the
+// CRN's certificate pair), returning the upstream status code and body
+// verbatim.
+func runRequest(r *http.Request, spec EndpointSpec, jsonData map[string]any, identity string) (int, []byte, error) {
+	if err := validateFields(jsonData, spec.RequiredFields); err != nil {
+		return 0, nil, err
+	}
+	crn := jsonData["crn"].(string)
 
-	output, err := virtualHdm.Output()
-	if err != nil {
-		return "", "", fmt.Errorf("error uploading certificates: %v\nResponse:\n%s", err, output)
+	if err := authorizeCRN(identity, crn); err != nil {
+		return 0, nil, err
 	}
 
-	log.Println("Certificates uploaded successfully")
+	ctx := r.Context()
 
-	return certPath, keyPath, nil
-}
+	if spec.UpstreamPath == "uploadCertificate" {
+		certPath, keyPath, err := findCertificateFiles(crn)
+		if err != nil {
+			return 0, nil, ErrCertNotFound(crn, err)
+		}
 
-func runCurlCommand(endpointKey string, jsonData map[string]any) (string, error) {
-	endpoint, exists := endpoints[endpointKey]
+		status, body, err := registerCertificate(ctx, certPath, keyPath, crn)
+		if err != nil {
+			return 0, nil, err
+		}
+		if status < 200 || status >= 300 {
+			return 0, nil, ErrUpstream(status, body)
+		}
+		invalidateClient(crn)
+		watchUploadedCert(crn, certPath, keyPath)
+		return status, body, nil
+	}
+
+	method, exists := clientMethods[spec.UpstreamPath]
 	if !exists {
-		return "", fmt.Errorf("unknown endpoint %s", endpointKey)
+		return 0, nil, fmt.Errorf("unknown endpoint %s", spec.UpstreamPath)
+	}
+
+	client, err := clientFor(ctx, crn)
+	if err != nil {
+		return 0, nil, err
 	}
 
-	jsonBytes, err := json.Marshal(jsonData)
+	status, body, err := method(client, ctx, jsonData)
 	if err != nil {
-		return "", fmt.Errorf("JSON encoding error: %v", err)
+		return 0, nil, err
 	}
+	if status < 200 || status >= 300 {
+		return 0, nil, ErrUpstream(status, body)
+	}
+
+	return status, body, nil
+}
 
-	crnInterface, ok := jsonData["crn"]
-	if !ok {
-		return "", fmt.Errorf("field 'crn' is missing")
+// runProxyRequest forwards jsonData to upstreamPath verbatim, for taxservice
+// endpoints that have no EndpointSpec of their own yet. It only requires
+// crn, since the proxy doesn't know an arbitrary endpoint's other fields.
+func runProxyRequest(r *http.Request, upstreamPath string, jsonData map[string]any, identity string) (int, []byte, error) {
+	if err := validateFields(jsonData, []string{"crn"}); err != nil {
+		return 0, nil, err
 	}
+	crn := jsonData["crn"].(string)
 
-	crn, ok := crnInterface.(string)
-	if !ok {
-		return "", fmt.Errorf("field 'crn' must be a string")
+	if err := authorizeCRN(identity, crn); err != nil {
+		return 0, nil, err
 	}
 
-	_, err = strconv.Atoi(crn)
+	ctx := r.Context()
+
+	client, err := clientFor(ctx, crn)
 	if err != nil {
-		return "", fmt.Errorf("field 'crn' must contain only numbers")
+		return 0, nil, err
 	}
 
-	certPath, keyPath, err := checkCertificates(crn)
+	status, body, err := client.do(ctx, upstreamPath, jsonData)
 	if err != nil {
-		return "", err
+		return 0, nil, err
+	}
+	if status < 200 || status >= 300 {
+		return 0, nil, ErrUpstream(status, body)
 	}
 
-	virtualHdm := exec.Command(
-		"curl", "-X", "POST",
-		baseURL+endpoint,
-		"-H", "Content-Type: application/json",
-		"--cert", certPath,
-		"--key", keyPath,
-		"-d", string(jsonBytes),
-	)
+	return status, body, nil
+}
 
-	output, err := virtualHdm.Output()
+// decodeJSONBody reads r's body and unmarshals it as a JSON object.
+func decodeJSONBody(r *http.Request) (map[string]any, error) {
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		return "", fmt.Errorf("error in %s: %v\nResponse:\n%s", endpointKey, err, output)
+		return nil, fmt.Errorf("reading request body: %w", err)
+	}
+	defer r.Body.Close()
+
+	var jsonData map[string]any
+	if err := json.Unmarshal(body, &jsonData); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
 	}
 
-	fmt.Printf("%s result: %s\n", endpointKey, string(output))
-	return string(output), nil
+	return jsonData, nil
 }
 
-func handleRequest(w http.ResponseWriter, r *http.Request, endpointKey string) {
-	if r.Method != http.MethodPost {
-		currentTime := time.Now().Format("2006-01-02T15:04:05.000") + "+0000"
-		errorResponse := map[string]interface{}{
-			"timestamp": currentTime,
-			"status":    405,
-			"error":     "Method Not Allowed",
-			"message":   "Request method 'GET' not supported",
-			"path":      r.URL.Path,
-		}
-
-		jsonResponse, err := json.Marshal(errorResponse)
-		if err != nil {
-			http.Error(w, "Error marshaling JSON", http.StatusInternalServerError)
-			return
-		}
+func handleRequest(w http.ResponseWriter, r *http.Request, spec EndpointSpec) {
+	if r.Method != spec.Method {
+		render.Error(w, r, &apiError{
+			status:  http.StatusMethodNotAllowed,
+			message: fmt.Sprintf("Request method '%s' not supported", r.Method),
+		})
+		return
+	}
 
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(jsonResponse)
+	identity, err := authenticate(r)
+	if err != nil {
+		render.Error(w, r, err)
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
+	jsonData, err := decodeJSONBody(r)
 	if err != nil {
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		render.BadRequest(w, r, err)
 		return
 	}
-	defer r.Body.Close()
 
-	var jsonData map[string]any
-	err = json.Unmarshal(body, &jsonData)
+	status, response, err := runRequest(r, spec, jsonData, identity)
 	if err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		render.Error(w, r, err)
 		return
 	}
 
-	crnInterface, ok := jsonData["crn"]
-	if !ok {
-		http.Error(w, "Field 'crn' is missing", http.StatusBadRequest)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+// handleProxy serves /proxy/{name}, forwarding any name present in the
+// upstream taxservice API without requiring an EndpointSpec for it. This
+// covers endpoints the taxservice adds after this binary ships.
+func handleProxy(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/proxy/")
+	if name == "" || strings.Contains(name, "/") {
+		render.BadRequest(w, r, fmt.Errorf("missing proxy endpoint name in path %s", r.URL.Path))
 		return
 	}
 
-	crn, ok := crnInterface.(string)
-	if !ok {
-		http.Error(w, "Field 'crn' must be a string", http.StatusBadRequest)
+	if r.Method != http.MethodPost {
+		render.Error(w, r, &apiError{
+			status:  http.StatusMethodNotAllowed,
+			message: fmt.Sprintf("Request method '%s' not supported", r.Method),
+		})
 		return
 	}
 
-	_, err = strconv.Atoi(crn)
+	identity, err := authenticate(r)
 	if err != nil {
-		http.Error(w, "Field 'crn' must contain only numbers", http.StatusBadRequest)
+		render.Error(w, r, err)
 		return
 	}
 
-	response, err := runCurlCommand(endpointKey, jsonData)
+	jsonData, err := decodeJSONBody(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error processing request: %v", err), http.StatusInternalServerError)
+		render.BadRequest(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(response))
+	status, response, err := runProxyRequest(r, name, jsonData, identity)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
 }
 
 func main() {
 	flag.Parse()
 
-	http.HandleFunc("/checkConnection", func(w http.ResponseWriter, r *http.Request) {
-		handleRequest(w, r, "checkConnection")
-	})
-	http.HandleFunc("/activate", func(w http.ResponseWriter, r *http.Request) {
-		handleRequest(w, r, "activate")
-	})
-	http.HandleFunc("/configureDepartments", func(w http.ResponseWriter, r *http.Request) {
-		handleRequest(w, r, "configureDepartments")
-	})
-	http.HandleFunc("/getGoodList", func(w http.ResponseWriter, r *http.Request) {
-		handleRequest(w, r, "getGoodList")
-	})
-	http.HandleFunc("/print", func(w http.ResponseWriter, r *http.Request) {
-		handleRequest(w, r, "print")
-	})
-	http.HandleFunc("/printCopy", func(w http.ResponseWriter, r *http.Request) {
-		handleRequest(w, r, "printCopy")
-	})
-	http.HandleFunc("/getReturnedReceiptInfo", func(w http.ResponseWriter, r *http.Request) {
-		handleRequest(w, r, "getReturnedReceiptInfo")
-	})
-	http.HandleFunc("/printReturnReceipt", func(w http.ResponseWriter, r *http.Request) {
-		handleRequest(w, r, "printReturnReceipt")
-	})
-	http.HandleFunc("/uploadCertificate", func(w http.ResponseWriter, r *http.Request) {
-		handleRequest(w, r, "uploadCertificate")
-	})
+	if *clientCA != "" && !*listenTLS {
+		log.Fatal("-client-ca requires -listen-tls")
+	}
+	if *authTokenSecret != "" && !*listenTLS {
+		log.Fatal("-auth-token-secret requires -listen-tls, otherwise bearer tokens travel in plaintext")
+	}
+	if err := loadACL(); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, spec := range endpoints {
+		spec := spec
+		http.HandleFunc(spec.Path, func(w http.ResponseWriter, r *http.Request) {
+			handleRequest(w, r, spec)
+		})
+	}
+	http.HandleFunc("/proxy/", handleProxy)
+	http.HandleFunc("/ws/", handleWS)
+	http.HandleFunc("/certificates", handleCertificates)
+
+	startCertRenewer(&commandRenewer{cmd: *renewCmd})
+
+	if *listenTLS {
+		tlsConfig, err := serverTLSConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		server := &http.Server{Addr: ":8019", TLSConfig: tlsConfig}
+		log.Println("Server is running :8019 (TLS)")
+		log.Fatal(server.ListenAndServeTLS("", ""))
+		return
+	}
 
 	log.Println("Server is running :8019")
 	log.Fatal(http.ListenAndServe(":8019", nil))
 }
-
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"virtualHDM/internal/api/render"
+)
+
+// renewCmd names an external command that issues a fresh certificate for a
+// CRN. It is invoked as `<cmd> <crn>` and must print a JSON object
+// `{"cert": "<PEM>", "key": "<PEM>"}` on stdout. Left unset, the default
+// Renewer refuses to renew and the expiring certificate is only logged.
+var renewCmd = flag.String("renew-cmd", "", "command invoked as '<cmd> <crn>' to issue a renewed certificate, printing {\"cert\":...,\"key\":...} JSON to stdout")
+
+// expiryWarningWindow is how close to expiry a certificate has to be before
+// startup and renewal logging escalates to a warning.
+const expiryWarningWindow = 7 * 24 * time.Hour
+
+// CertInfo describes a loaded certificate's identity and validity window.
+type CertInfo struct {
+	CRN      string
+	CertPath string
+	KeyPath  string
+	NotAfter time.Time
+}
+
+// certRegistry holds the most recently known CertInfo for every CRN the
+// renewer is watching, keyed by CRN, so GET /certificates can report live
+// expiry data without touching disk.
+var certRegistry sync.Map // crn string -> CertInfo
+
+// activeRenewer is the Renewer passed to startCertRenewer, kept so a
+// certificate uploaded after startup via uploadCertificate can be watched
+// with the same renewal logic as the certificates discovered at boot.
+var activeRenewer Renewer
+
+// watchedCRNs tracks which CRNs already have a watchCert goroutine running,
+// so re-uploading a certificate for a CRN already being watched updates
+// certRegistry without spawning a second, racing watcher.
+var watchedCRNs sync.Map // crn string -> struct{}
+
+// Renewer issues a fresh certificate and key for crn. The default
+// implementation shells out to -renew-cmd; a step-ca ACME client could
+// satisfy the same interface.
+type Renewer interface {
+	Renew(ctx context.Context, crn string) (certPEM, keyPEM []byte, err error)
+}
+
+// commandRenewer is the default Renewer: it runs an external command and
+// parses its JSON stdout.
+type commandRenewer struct {
+	cmd string
+}
+
+func (r *commandRenewer) Renew(ctx context.Context, crn string) ([]byte, []byte, error) {
+	if r.cmd == "" {
+		return nil, nil, fmt.Errorf("no -renew-cmd configured, cannot renew certificate for crn %s", crn)
+	}
+
+	output, err := exec.CommandContext(ctx, r.cmd, crn).Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("renew command failed for crn %s: %w", crn, err)
+	}
+
+	var issued struct {
+		Cert string `json:"cert"`
+		Key  string `json:"key"`
+	}
+	if err := json.Unmarshal(output, &issued); err != nil {
+		return nil, nil, fmt.Errorf("parsing renew command output for crn %s: %w", crn, err)
+	}
+
+	return []byte(issued.Cert), []byte(issued.Key), nil
+}
+
+// startCertRenewer scans certs/ for existing certificates, registers each
+// one's expiry in certRegistry, warns about any already inside the
+// expiryWarningWindow, and starts a per-CRN goroutine that renews the
+// certificate once it crosses its renewal threshold.
+func startCertRenewer(renewer Renewer) {
+	activeRenewer = renewer
+
+	infos, err := discoverCerts()
+	if err != nil {
+		log.Printf("discovering certificates: %v", err)
+		return
+	}
+
+	for _, info := range infos {
+		certRegistry.Store(info.CRN, info)
+		watchedCRNs.Store(info.CRN, struct{}{})
+		warnIfExpiringSoon(info)
+		go watchCert(info, renewer)
+	}
+}
+
+// watchUploadedCert loads the certificate pair just registered for crn via
+// uploadCertificate and adds it to certRegistry, starting a watchCert
+// goroutine for it if one isn't already running, so a CRN uploaded after
+// startup gets the same revocation/rotation path as one discovered under
+// certs/ at boot.
+func watchUploadedCert(crn, certPath, keyPath string) {
+	info, err := loadCertInfo(crn, certPath, keyPath)
+	if err != nil {
+		log.Printf("watching uploaded certificate for crn %s: %v", crn, err)
+		return
+	}
+
+	certRegistry.Store(crn, info)
+	warnIfExpiringSoon(info)
+
+	if _, alreadyWatched := watchedCRNs.LoadOrStore(crn, struct{}{}); !alreadyWatched {
+		go watchCert(info, activeRenewer)
+	}
+}
+
+func warnIfExpiringSoon(info CertInfo) {
+	if remaining := time.Until(info.NotAfter); remaining < expiryWarningWindow {
+		log.Printf("WARNING: certificate for crn %s expires %s (in %s)", info.CRN, info.NotAfter.Format(time.RFC3339), remaining.Round(time.Hour))
+	}
+}
+
+// discoverCerts finds one CertInfo per CRN present under certs/, inferring
+// the CRN from the leading digits of each .crt file's base name.
+func discoverCerts() ([]CertInfo, error) {
+	matches, err := filepath.Glob(filepath.Join("certs", "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var infos []CertInfo
+	for _, match := range matches {
+		base := strings.TrimSuffix(filepath.Base(match), filepath.Ext(match))
+		crn := crnFromFilename(base)
+		if crn == "" || seen[crn] {
+			continue
+		}
+		seen[crn] = true
+
+		certPath, keyPath, err := findCertificateFiles(crn)
+		if err != nil {
+			log.Printf("skipping crn %s: %v", crn, err)
+			continue
+		}
+
+		info, err := loadCertInfo(crn, certPath, keyPath)
+		if err != nil {
+			log.Printf("skipping crn %s: %v", crn, err)
+			continue
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// crnFromFilename extracts the leading run of digits from a certificate
+// file's base name, matching the certs/<crn>*.crt convention used by
+// findCertificateFiles.
+func crnFromFilename(base string) string {
+	i := 0
+	for i < len(base) && base[i] >= '0' && base[i] <= '9' {
+		i++
+	}
+	return base[:i]
+}
+
+func loadCertInfo(crn, certPath, keyPath string) (CertInfo, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return CertInfo{}, fmt.Errorf("reading %s: %w", certPath, err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return CertInfo{}, fmt.Errorf("no PEM block found in %s", certPath)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return CertInfo{}, fmt.Errorf("parsing certificate %s: %w", certPath, err)
+	}
+
+	return CertInfo{CRN: crn, CertPath: certPath, KeyPath: keyPath, NotAfter: cert.NotAfter}, nil
+}
+
+// renewAt computes the point, modelled on step-ca's ca/renew.go, at which a
+// certificate valid from notBefore to notAfter should be renewed: one third
+// of its lifetime before expiry.
+func renewAt(notBefore, notAfter time.Time) time.Time {
+	lifetime := notAfter.Sub(notBefore)
+	return notAfter.Add(-lifetime / 3)
+}
+
+// watchCert sleeps until info's renewal threshold, renews it, and repeats
+// against the newly issued certificate's own threshold. A failed renewal is
+// logged and retried after an hour rather than leaving the cert unwatched.
+func watchCert(info CertInfo, renewer Renewer) {
+	for {
+		certPEM, err := os.ReadFile(info.CertPath)
+		if err != nil {
+			log.Printf("watching certificate for crn %s: %v", info.CRN, err)
+			return
+		}
+		block, _ := pem.Decode(certPEM)
+		if block == nil {
+			log.Printf("watching certificate for crn %s: no PEM block in %s", info.CRN, info.CertPath)
+			return
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			log.Printf("watching certificate for crn %s: %v", info.CRN, err)
+			return
+		}
+
+		wait := time.Until(renewAt(cert.NotBefore, cert.NotAfter))
+		if wait < 0 {
+			wait = 0
+		}
+
+		timer := time.NewTimer(wait)
+		<-timer.C
+
+		newInfo, err := renewCertificate(info, renewer)
+		if err != nil {
+			log.Printf("WARNING: renewing certificate for crn %s failed, retrying in 1h: %v", info.CRN, err)
+			time.Sleep(time.Hour)
+			continue
+		}
+
+		info = newInfo
+	}
+}
+
+// renewCertificate asks renewer for a new certificate, registers it with
+// ecrm.taxservice.am, atomically replaces the files on disk, invalidates the
+// cached mTLS client for the CRN, and updates certRegistry.
+func renewCertificate(info CertInfo, renewer Renewer) (CertInfo, error) {
+	ctx := context.Background()
+
+	certPEM, keyPEM, err := renewer.Renew(ctx, info.CRN)
+	if err != nil {
+		return info, err
+	}
+
+	tmpCert, err := atomicWriteTemp(info.CertPath, certPEM)
+	if err != nil {
+		return info, err
+	}
+	tmpKey, err := atomicWriteTemp(info.KeyPath, keyPEM)
+	if err != nil {
+		return info, err
+	}
+
+	status, body, err := registerCertificate(ctx, tmpCert, tmpKey, info.CRN)
+	if err != nil {
+		return info, fmt.Errorf("registering renewed certificate for crn %s: %w", info.CRN, err)
+	}
+	if status < 200 || status >= 300 {
+		os.Remove(tmpCert)
+		os.Remove(tmpKey)
+		return info, fmt.Errorf("registering renewed certificate for crn %s: %w", info.CRN, ErrUpstream(status, body))
+	}
+
+	if err := os.Rename(tmpCert, info.CertPath); err != nil {
+		return info, fmt.Errorf("installing renewed certificate for crn %s: %w", info.CRN, err)
+	}
+	if err := os.Rename(tmpKey, info.KeyPath); err != nil {
+		return info, fmt.Errorf("installing renewed key for crn %s: %w", info.CRN, err)
+	}
+
+	invalidateClient(info.CRN)
+
+	newInfo, err := loadCertInfo(info.CRN, info.CertPath, info.KeyPath)
+	if err != nil {
+		return info, fmt.Errorf("reloading renewed certificate for crn %s: %w", info.CRN, err)
+	}
+
+	certRegistry.Store(info.CRN, newInfo)
+	log.Printf("renewed certificate for crn %s, new expiry %s", info.CRN, newInfo.NotAfter.Format(time.RFC3339))
+	warnIfExpiringSoon(newInfo)
+
+	return newInfo, nil
+}
+
+// atomicWriteTemp writes data to a sibling temp file of finalPath and
+// returns its path, so the caller can register it before renaming it into
+// place and never leaves a half-written certificate on disk.
+func atomicWriteTemp(finalPath string, data []byte) (string, error) {
+	tmpPath := finalPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return "", fmt.Errorf("writing %s: %w", tmpPath, err)
+	}
+	return tmpPath, nil
+}
+
+// certStatus is the GET /certificates response shape for a single CRN.
+type certStatus struct {
+	CRN           string  `json:"crn"`
+	NotAfter      string  `json:"notAfter"`
+	DaysRemaining float64 `json:"daysRemaining"`
+}
+
+// handleCertificates lists every watched certificate's expiry so operators
+// can monitor a fleet of CRNs without reading certs/ by hand.
+func handleCertificates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		render.Error(w, r, &apiError{
+			status:  http.StatusMethodNotAllowed,
+			message: fmt.Sprintf("Request method '%s' not supported", r.Method),
+		})
+		return
+	}
+
+	identity, err := authenticate(r)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	var statuses []certStatus
+	certRegistry.Range(func(key, value any) bool {
+		info := value.(CertInfo)
+		if authorizeCRN(identity, info.CRN) != nil {
+			return true
+		}
+		statuses = append(statuses, certStatus{
+			CRN:           info.CRN,
+			NotAfter:      info.NotAfter.Format(time.RFC3339),
+			DaysRemaining: time.Until(info.NotAfter).Hours() / 24,
+		})
+		return true
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		log.Printf("encoding /certificates response: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRenewAt(t *testing.T) {
+	cases := []struct {
+		name       string
+		lifetime   time.Duration
+		wantBefore time.Duration // how long before notAfter renewAt should land
+	}{
+		{name: "90 day cert", lifetime: 90 * 24 * time.Hour, wantBefore: 30 * 24 * time.Hour},
+		{name: "1 year cert", lifetime: 365 * 24 * time.Hour, wantBefore: 365 * 24 * time.Hour / 3},
+		{name: "1 hour cert", lifetime: time.Hour, wantBefore: 20 * time.Minute},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+			notAfter := notBefore.Add(tc.lifetime)
+
+			got := renewAt(notBefore, notAfter)
+			want := notAfter.Add(-tc.wantBefore)
+
+			if !got.Equal(want) {
+				t.Errorf("renewAt(%v, %v) = %v, want %v", notBefore, notAfter, got, want)
+			}
+		})
+	}
+}
+
+func TestCrnFromFilename(t *testing.T) {
+	cases := []struct {
+		base string
+		want string
+	}{
+		{base: "123456", want: "123456"},
+		{base: "123456-prod", want: "123456"},
+		{base: "123456_2026", want: "123456"},
+		{base: "no-digits-here", want: ""},
+		{base: "", want: ""},
+	}
+
+	for _, tc := range cases {
+		if got := crnFromFilename(tc.base); got != tc.want {
+			t.Errorf("crnFromFilename(%q) = %q, want %q", tc.base, got, tc.want)
+		}
+	}
+}
+
+func TestDiscoverCerts(t *testing.T) {
+	dir := t.TempDir()
+	certsDir := filepath.Join(dir, "certs")
+	if err := os.Mkdir(certsDir, 0o755); err != nil {
+		t.Fatalf("mkdir certs: %v", err)
+	}
+	generateSelfSignedCert(t, certsDir, "123456")
+	generateSelfSignedCert(t, certsDir, "654321")
+
+	oldWd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(oldWd) })
+
+	infos, err := discoverCerts()
+	if err != nil {
+		t.Fatalf("discoverCerts: %v", err)
+	}
+
+	gotCRNs := map[string]bool{}
+	for _, info := range infos {
+		gotCRNs[info.CRN] = true
+		if info.NotAfter.IsZero() {
+			t.Errorf("crn %s: NotAfter is zero", info.CRN)
+		}
+	}
+
+	for _, want := range []string{"123456", "654321"} {
+		if !gotCRNs[want] {
+			t.Errorf("discoverCerts did not find crn %s, got %v", want, infos)
+		}
+	}
+}
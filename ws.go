@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+
+	"virtualHDM/internal/api/render"
+)
+
+// wsEvent is one message in the status stream a /ws/{endpoint} connection
+// sends while an upstream call is in flight. type is the only field every
+// event shares; the rest are populated as the call progresses.
+type wsEvent struct {
+	Type    string `json:"type"`
+	Status  int    `json:"status,omitempty"`
+	Body    string `json:"body,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// handleWS serves /ws/{endpoint}, streaming progress events for a request
+// that can otherwise block the caller for the many seconds a fiscal print
+// operation takes: {"type":"cert_loaded"}, {"type":"upstream_request"},
+// {"type":"upstream_response","status":200,"body":...} and
+// {"type":"error","message":...}. The first frame the client sends must be
+// the same JSON payload the equivalent REST endpoint expects.
+func handleWS(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/ws/")
+	if name == "" || strings.Contains(name, "/") {
+		render.BadRequest(w, r, fmt.Errorf("missing ws endpoint name in path %s", r.URL.Path))
+		return
+	}
+
+	identity, err := authenticate(r)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	c, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		log.Printf("ws %s: accept: %v", name, err)
+		return
+	}
+	defer c.CloseNow()
+
+	var jsonData map[string]any
+	if err := wsjson.Read(r.Context(), c, &jsonData); err != nil {
+		log.Printf("ws %s: reading request frame: %v", name, err)
+		return
+	}
+
+	// Only control frames (ping/pong/close) are read from here on, so the
+	// returned context is cancelled the moment the client disconnects,
+	// letting that cancellation reach the in-flight upstream request.
+	ctx := c.CloseRead(r.Context())
+
+	send := func(event wsEvent) {
+		if err := wsjson.Write(ctx, c, event); err != nil {
+			log.Printf("ws %s: writing %s event: %v", name, event.Type, err)
+		}
+	}
+
+	status, body, err := runRequestWS(ctx, name, jsonData, identity, send)
+	if err != nil {
+		send(wsEvent{Type: "error", Message: err.Error()})
+		c.Close(websocket.StatusNormalClosure, "upstream call failed")
+		return
+	}
+
+	send(wsEvent{Type: "upstream_response", Status: status, Body: string(body)})
+	c.Close(websocket.StatusNormalClosure, "")
+}
+
+// runRequestWS mirrors runRequest/runProxyRequest but emits a progress event
+// at each stage of the call via send, and uses the matching EndpointSpec's
+// RequiredFields when upstreamPath names a known endpoint (falling back to
+// requiring just crn, the same as /proxy/{name}, for endpoints the
+// taxservice has added since this binary shipped).
+func runRequestWS(ctx context.Context, upstreamPath string, jsonData map[string]any, identity string, send func(wsEvent)) (int, []byte, error) {
+	if upstreamPath == "uploadCertificate" {
+		return 0, nil, errors.New("uploadCertificate is not supported over /ws, use the REST endpoint")
+	}
+
+	required := []string{"crn"}
+	for _, spec := range endpoints {
+		if spec.UpstreamPath == upstreamPath {
+			required = spec.RequiredFields
+			break
+		}
+	}
+
+	if err := validateFields(jsonData, required); err != nil {
+		return 0, nil, err
+	}
+	crn := jsonData["crn"].(string)
+
+	if err := authorizeCRN(identity, crn); err != nil {
+		return 0, nil, err
+	}
+
+	client, err := clientFor(ctx, crn)
+	if err != nil {
+		return 0, nil, err
+	}
+	send(wsEvent{Type: "cert_loaded"})
+
+	send(wsEvent{Type: "upstream_request"})
+	status, body, err := client.do(ctx, upstreamPath, jsonData)
+	if err != nil {
+		return 0, nil, err
+	}
+	if status < 200 || status >= 300 {
+		return 0, nil, ErrUpstream(status, body)
+	}
+
+	return status, body, nil
+}